@@ -0,0 +1,37 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import "github.com/matrix-org/gomatrixserverlib"
+
+// PerformPeekRequest asks the federationsender to start an MSC2753 peek of
+// RoomID on ServerName on behalf of UserID/DeviceID: that is, to PUT
+// /peek/{roomID}/{peekID} to ServerName, persist the room state and auth
+// chain it gets back, and keep renewing the peek until PerformUnpeek is
+// called for the same (RoomID, ServerName, UserID, DeviceID).
+type PerformPeekRequest struct {
+	RoomID     string
+	ServerName gomatrixserverlib.ServerName
+	UserID     string
+	DeviceID   string
+}
+
+// PerformPeekResponse is the result of a successful PerformPeekRequest. By
+// the time this is returned, the room has already been fed into the
+// roomserver via the usual input path, so callers do not need to do
+// anything further with LatestEventIDs other than use it for logging.
+type PerformPeekResponse struct {
+	LatestEventIDs []string
+}
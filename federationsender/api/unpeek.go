@@ -0,0 +1,31 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import "github.com/matrix-org/gomatrixserverlib"
+
+// PerformUnpeekRequest asks the federationsender to tear down a
+// previously-established MSC2753 peek of RoomID on ServerName, by sending
+// DELETE /peek/{roomID}/{peekID}, and to stop any background renewal of it.
+type PerformUnpeekRequest struct {
+	RoomID     string
+	ServerName gomatrixserverlib.ServerName
+	UserID     string
+	DeviceID   string
+}
+
+// PerformUnpeekResponse is the (currently empty) result of a successful
+// PerformUnpeekRequest.
+type PerformUnpeekResponse struct{}
@@ -0,0 +1,62 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package internal contains the concrete implementation of the
+// federationsender's internal API used by fsAPI.FederationSenderInternalAPI
+// callers such as the roomserver. Only the peek/unpeek surface is defined
+// here; the rest of the federationsender's internal API (directory lookups,
+// joins, invites, etc.) lives alongside it in the wider package.
+package internal
+
+import (
+	"context"
+	"sync"
+
+	rsAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// FederationSenderInternalAPI is the concrete implementation of
+// fsAPI.FederationSenderInternalAPI.
+type FederationSenderInternalAPI struct {
+	Cfg              *config.Dendrite
+	FederationClient federationPeekClient
+	RSAPI            rsAPI.RoomserverInputAPI
+
+	peeksMu sync.Mutex
+	peeks   map[peekKey]*peekState
+}
+
+// federationPeekClient is the subset of gomatrixserverlib.FederationClient
+// that the peek lifecycle in this package needs. gomatrixserverlib.FederationClient
+// satisfies it, but declaring it separately means PerformPeek/PerformUnpeek
+// can be unit tested against a small fake instead of one standing in for
+// FederationClient's entire, much larger surface.
+type federationPeekClient interface {
+	Peek(ctx context.Context, origin, s gomatrixserverlib.ServerName, roomID, peekID string) (gomatrixserverlib.RespPeek, error)
+	Unpeek(ctx context.Context, origin, s gomatrixserverlib.ServerName, roomID, peekID string) error
+}
+
+// peekKey identifies a single (room, server, user, device) federated peek.
+type peekKey struct {
+	roomID, serverName, userID, deviceID string
+}
+
+// peekState tracks an in-flight MSC2753 peek so that it can be renewed
+// periodically by PerformPeek and torn down cleanly by PerformUnpeek.
+type peekState struct {
+	peekID string
+	cancel context.CancelFunc
+}
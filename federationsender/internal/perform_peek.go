@@ -0,0 +1,172 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	fsAPI "github.com/matrix-org/dendrite/federationsender/api"
+	rsAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+// peekRenewalInterval is how often we re-send the peek PUT to the remote
+// server to keep it alive. MSC2753 leaves the exact expiry up to the
+// implementation; this comfortably beats the minute-scale expiries servers
+// are expected to use.
+const peekRenewalInterval = 30 * time.Second
+
+// PerformPeek implements fsAPI.FederationSenderInternalAPI. It asks
+// req.ServerName to let us peek into req.RoomID by sending an MSC2753
+// PUT /peek/{roomID}/{peekID}, feeds the state and auth chain it returns
+// into the roomserver the same way a federated join would, and then keeps
+// renewing the peek in the background until PerformUnpeek is called for the
+// same (room, server, user, device).
+func (f *FederationSenderInternalAPI) PerformPeek(
+	ctx context.Context,
+	req *fsAPI.PerformPeekRequest,
+	res *fsAPI.PerformPeekResponse,
+) error {
+	key := peekKey{req.RoomID, string(req.ServerName), req.UserID, req.DeviceID}
+
+	f.peeksMu.Lock()
+	_, alreadyPeeking := f.peeks[key]
+	f.peeksMu.Unlock()
+	if alreadyPeeking {
+		// Re-peeking from the same device is a no-op, not an error.
+		return nil
+	}
+
+	peekID := util.RandomString(16)
+	respPeek, err := f.FederationClient.Peek(ctx, f.Cfg.Matrix.ServerName, req.ServerName, req.RoomID, peekID)
+	if err != nil {
+		return fmt.Errorf("federationsender: peek of room %q on %q failed: %w", req.RoomID, req.ServerName, err)
+	}
+
+	latestEventIDs, err := f.persistPeekedRoom(ctx, respPeek)
+	if err != nil {
+		return fmt.Errorf("federationsender: failed to persist peeked room %q: %w", req.RoomID, err)
+	}
+	res.LatestEventIDs = latestEventIDs
+
+	renewCtx, cancel := context.WithCancel(context.Background())
+	f.peeksMu.Lock()
+	if f.peeks == nil {
+		f.peeks = map[peekKey]*peekState{}
+	}
+	f.peeks[key] = &peekState{peekID: peekID, cancel: cancel}
+	f.peeksMu.Unlock()
+
+	go f.renewPeek(renewCtx, key.roomID, req.ServerName, peekID)
+
+	return nil
+}
+
+// persistPeekedRoom feeds the room state and auth chain obtained from a
+// successful peek into the roomserver: the auth chain and state events are
+// submitted as outliers so they're indexed for auth/state resolution, and
+// the latest events are submitted as new events with that state attached -
+// mirroring the way a federated join submits what it gets back from
+// /send_join.
+func (f *FederationSenderInternalAPI) persistPeekedRoom(
+	ctx context.Context,
+	respPeek gomatrixserverlib.RespPeek,
+) ([]string, error) {
+	ire := make([]rsAPI.InputRoomEvent, 0, len(respPeek.AuthChainEvents)+len(respPeek.StateEvents)+len(respPeek.LatestEvents))
+	stateEventIDs := make([]string, 0, len(respPeek.StateEvents))
+
+	for _, event := range respPeek.AuthChainEvents {
+		ire = append(ire, rsAPI.InputRoomEvent{
+			Kind:  rsAPI.KindOutlier,
+			Event: event.Headered(respPeek.RoomVersion),
+		})
+	}
+	for _, event := range respPeek.StateEvents {
+		ire = append(ire, rsAPI.InputRoomEvent{
+			Kind:  rsAPI.KindOutlier,
+			Event: event.Headered(respPeek.RoomVersion),
+		})
+		stateEventIDs = append(stateEventIDs, event.EventID())
+	}
+
+	latestEventIDs := make([]string, 0, len(respPeek.LatestEvents))
+	for _, event := range respPeek.LatestEvents {
+		latestEventIDs = append(latestEventIDs, event.EventID())
+		ire = append(ire, rsAPI.InputRoomEvent{
+			Kind:          rsAPI.KindNew,
+			Event:         event.Headered(respPeek.RoomVersion),
+			AuthEventIDs:  event.AuthEventIDs(),
+			HasState:      true,
+			StateEventIDs: stateEventIDs,
+		})
+	}
+
+	var ireRes rsAPI.InputRoomEventsResponse
+	if err := f.RSAPI.InputRoomEvents(ctx, &rsAPI.InputRoomEventsRequest{InputRoomEvents: ire}, &ireRes); err != nil {
+		return nil, err
+	}
+	if ireRes.ErrMsg != "" {
+		return nil, fmt.Errorf("%s", ireRes.ErrMsg)
+	}
+	return latestEventIDs, nil
+}
+
+// renewPeek re-sends the MSC2753 peek PUT every peekRenewalInterval until
+// ctx is cancelled by PerformUnpeek, so that the remote server doesn't
+// expire our subscription.
+func (f *FederationSenderInternalAPI) renewPeek(
+	ctx context.Context,
+	roomID string,
+	serverName gomatrixserverlib.ServerName,
+	peekID string,
+) {
+	ticker := time.NewTicker(peekRenewalInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.renewPeekOnce(ctx, roomID, serverName, peekID); err != nil {
+				util.GetLogger(ctx).WithError(err).WithField("room_id", roomID).
+					WithField("server_name", serverName).Warn("Failed to renew federated peek")
+			}
+		}
+	}
+}
+
+// renewPeekOnce re-sends the MSC2753 peek PUT once and feeds whatever room
+// state or new events it gets back into the roomserver via
+// persistPeekedRoom, exactly as the initial PerformPeek call does - a
+// renewal is just as capable of carrying new events/state as the original
+// PUT is, so dropping its response would mean a peeking server stops
+// receiving updates after the first tick. Pulled out of renewPeek's ticker
+// loop so this behaviour can be unit tested without waiting on a real timer.
+func (f *FederationSenderInternalAPI) renewPeekOnce(
+	ctx context.Context,
+	roomID string,
+	serverName gomatrixserverlib.ServerName,
+	peekID string,
+) error {
+	respPeek, err := f.FederationClient.Peek(ctx, f.Cfg.Matrix.ServerName, serverName, roomID, peekID)
+	if err != nil {
+		return err
+	}
+	_, err = f.persistPeekedRoom(ctx, respPeek)
+	return err
+}
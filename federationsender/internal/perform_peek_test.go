@@ -0,0 +1,177 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	fsAPI "github.com/matrix-org/dendrite/federationsender/api"
+	rsAPI "github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/setup/config"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// fakeFederationClient is a federationPeekClient that returns a
+// preconfigured sequence of responses from Peek, one per call, so that
+// tests can tell the initial PerformPeek call and later renewals apart.
+type fakeFederationClient struct {
+	peekResponses []gomatrixserverlib.RespPeek
+	peekErr       error
+	peekCalls     int
+	unpeekCalls   int
+}
+
+func (f *fakeFederationClient) Peek(ctx context.Context, origin, s gomatrixserverlib.ServerName, roomID, peekID string) (gomatrixserverlib.RespPeek, error) {
+	if f.peekErr != nil {
+		return gomatrixserverlib.RespPeek{}, f.peekErr
+	}
+	if f.peekCalls >= len(f.peekResponses) {
+		f.peekCalls++
+		return gomatrixserverlib.RespPeek{}, nil
+	}
+	resp := f.peekResponses[f.peekCalls]
+	f.peekCalls++
+	return resp, nil
+}
+
+func (f *fakeFederationClient) Unpeek(ctx context.Context, origin, s gomatrixserverlib.ServerName, roomID, peekID string) error {
+	f.unpeekCalls++
+	return nil
+}
+
+// fakeRSAPI is a rsAPI.RoomserverInputAPI that just records every request
+// it's given, so tests can assert on how many times, and with what, the
+// federationsender fed events into the roomserver.
+type fakeRSAPI struct {
+	gotRequests []*rsAPI.InputRoomEventsRequest
+}
+
+func (f *fakeRSAPI) InputRoomEvents(ctx context.Context, req *rsAPI.InputRoomEventsRequest, res *rsAPI.InputRoomEventsResponse) error {
+	f.gotRequests = append(f.gotRequests, req)
+	return nil
+}
+
+func newTestAPI(fc *fakeFederationClient, rs *fakeRSAPI) *FederationSenderInternalAPI {
+	return &FederationSenderInternalAPI{
+		Cfg:              &config.Dendrite{},
+		FederationClient: fc,
+		RSAPI:            rs,
+	}
+}
+
+func testPeekRequest(roomID string, serverName gomatrixserverlib.ServerName) fsAPI.PerformPeekRequest {
+	return fsAPI.PerformPeekRequest{
+		RoomID:     roomID,
+		ServerName: serverName,
+		UserID:     "@alice:test",
+		DeviceID:   "DEVICE",
+	}
+}
+
+func TestPerformPeekPersistsTheInitialResponse(t *testing.T) {
+	fc := &fakeFederationClient{peekResponses: []gomatrixserverlib.RespPeek{{RoomVersion: gomatrixserverlib.RoomVersionV4}}}
+	rs := &fakeRSAPI{}
+	f := newTestAPI(fc, rs)
+
+	req := testPeekRequest("!room:test", "remote.test")
+	var res fsAPI.PerformPeekResponse
+	if err := f.PerformPeek(context.Background(), &req, &res); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fc.peekCalls != 1 {
+		t.Fatalf("expected exactly one Peek call, got %d", fc.peekCalls)
+	}
+	if len(rs.gotRequests) != 1 {
+		t.Fatalf("expected PerformPeek to persist the response via InputRoomEvents, got %d calls", len(rs.gotRequests))
+	}
+}
+
+func TestPerformPeekIsIdempotentForTheSameDevice(t *testing.T) {
+	fc := &fakeFederationClient{peekResponses: []gomatrixserverlib.RespPeek{{}}}
+	rs := &fakeRSAPI{}
+	f := newTestAPI(fc, rs)
+
+	req := testPeekRequest("!room:test", "remote.test")
+	var res1, res2 fsAPI.PerformPeekResponse
+	if err := f.PerformPeek(context.Background(), &req, &res1); err != nil {
+		t.Fatalf("unexpected error on first peek: %s", err)
+	}
+	if err := f.PerformPeek(context.Background(), &req, &res2); err != nil {
+		t.Fatalf("unexpected error on second peek: %s", err)
+	}
+	if fc.peekCalls != 1 {
+		t.Fatalf("expected re-peeking the same device to be a no-op, but Peek was called %d times", fc.peekCalls)
+	}
+}
+
+func TestPerformPeekReturnsErrorWhenFederationClientFails(t *testing.T) {
+	fc := &fakeFederationClient{peekErr: errors.New("boom")}
+	rs := &fakeRSAPI{}
+	f := newTestAPI(fc, rs)
+
+	req := testPeekRequest("!room:test", "remote.test")
+	var res fsAPI.PerformPeekResponse
+	if err := f.PerformPeek(context.Background(), &req, &res); err == nil {
+		t.Fatal("expected an error when the federation client fails, got nil")
+	}
+	if len(rs.gotRequests) != 0 {
+		t.Fatalf("expected nothing to be persisted when the peek itself fails, got %d calls", len(rs.gotRequests))
+	}
+}
+
+func TestRenewPeekOncePersistsTheRenewalResponse(t *testing.T) {
+	fc := &fakeFederationClient{
+		peekResponses: []gomatrixserverlib.RespPeek{
+			{RoomVersion: gomatrixserverlib.RoomVersionV4}, // the initial peek
+			{RoomVersion: gomatrixserverlib.RoomVersionV4}, // a renewal carrying a fresh set of events
+		},
+	}
+	rs := &fakeRSAPI{}
+	f := newTestAPI(fc, rs)
+
+	req := testPeekRequest("!room:test", "remote.test")
+	var res fsAPI.PerformPeekResponse
+	if err := f.PerformPeek(context.Background(), &req, &res); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(rs.gotRequests) != 1 {
+		t.Fatalf("expected 1 persisted request after the initial peek, got %d", len(rs.gotRequests))
+	}
+
+	if err := f.renewPeekOnce(context.Background(), "!room:test", "remote.test", "some-peek-id"); err != nil {
+		t.Fatalf("unexpected error from renewPeekOnce: %s", err)
+	}
+	if fc.peekCalls != 2 {
+		t.Fatalf("expected renewPeekOnce to call Peek again, got %d total calls", fc.peekCalls)
+	}
+	if len(rs.gotRequests) != 2 {
+		t.Fatalf("expected renewPeekOnce to persist its response via InputRoomEvents same as the initial peek, got %d persisted requests", len(rs.gotRequests))
+	}
+}
+
+func TestRenewPeekOnceReturnsErrorWhenFederationClientFails(t *testing.T) {
+	fc := &fakeFederationClient{peekErr: errors.New("boom")}
+	rs := &fakeRSAPI{}
+	f := newTestAPI(fc, rs)
+
+	if err := f.renewPeekOnce(context.Background(), "!room:test", "remote.test", "some-peek-id"); err == nil {
+		t.Fatal("expected an error when the federation client fails, got nil")
+	}
+	if len(rs.gotRequests) != 0 {
+		t.Fatalf("expected nothing to be persisted when the renewal itself fails, got %d calls", len(rs.gotRequests))
+	}
+}
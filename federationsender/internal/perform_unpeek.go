@@ -0,0 +1,53 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	fsAPI "github.com/matrix-org/dendrite/federationsender/api"
+)
+
+// PerformUnpeek implements fsAPI.FederationSenderInternalAPI. It tears down
+// the MSC2753 peek with DELETE /peek/{roomID}/{peekID}, and stops the
+// background renewal goroutine that PerformPeek started for it.
+func (f *FederationSenderInternalAPI) PerformUnpeek(
+	ctx context.Context,
+	req *fsAPI.PerformUnpeekRequest,
+	res *fsAPI.PerformUnpeekResponse,
+) error {
+	key := peekKey{req.RoomID, string(req.ServerName), req.UserID, req.DeviceID}
+
+	f.peeksMu.Lock()
+	state, ok := f.peeks[key]
+	if ok {
+		delete(f.peeks, key)
+	}
+	f.peeksMu.Unlock()
+
+	if !ok {
+		// Nothing to tear down - PerformUnpeek is idempotent in the same
+		// way PerformPeek is.
+		return nil
+	}
+
+	state.cancel()
+
+	if err := f.FederationClient.Unpeek(ctx, f.Cfg.Matrix.ServerName, req.ServerName, req.RoomID, state.peekID); err != nil {
+		return fmt.Errorf("federationsender: unpeek of room %q on %q failed: %w", req.RoomID, req.ServerName, err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"testing"
+
+	fsAPI "github.com/matrix-org/dendrite/federationsender/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+func testUnpeekRequest(roomID string, serverName gomatrixserverlib.ServerName) fsAPI.PerformUnpeekRequest {
+	return fsAPI.PerformUnpeekRequest{
+		RoomID:     roomID,
+		ServerName: serverName,
+		UserID:     "@alice:test",
+		DeviceID:   "DEVICE",
+	}
+}
+
+func TestPerformUnpeekTearsDownATrackedPeek(t *testing.T) {
+	fc := &fakeFederationClient{peekResponses: []gomatrixserverlib.RespPeek{{}}}
+	rs := &fakeRSAPI{}
+	f := newTestAPI(fc, rs)
+
+	peekReq := testPeekRequest("!room:test", "remote.test")
+	var peekRes fsAPI.PerformPeekResponse
+	if err := f.PerformPeek(context.Background(), &peekReq, &peekRes); err != nil {
+		t.Fatalf("unexpected error setting up the peek: %s", err)
+	}
+
+	unpeekReq := testUnpeekRequest("!room:test", "remote.test")
+	var unpeekRes fsAPI.PerformUnpeekResponse
+	if err := f.PerformUnpeek(context.Background(), &unpeekReq, &unpeekRes); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fc.unpeekCalls != 1 {
+		t.Fatalf("expected exactly one Unpeek call, got %d", fc.unpeekCalls)
+	}
+
+	f.peeksMu.Lock()
+	_, stillTracked := f.peeks[peekKey{"!room:test", "remote.test", "@alice:test", "DEVICE"}]
+	f.peeksMu.Unlock()
+	if stillTracked {
+		t.Fatal("expected the peek to be removed from tracking after PerformUnpeek")
+	}
+}
+
+func TestPerformUnpeekIsIdempotentForAnUntrackedPeek(t *testing.T) {
+	fc := &fakeFederationClient{}
+	rs := &fakeRSAPI{}
+	f := newTestAPI(fc, rs)
+
+	unpeekReq := testUnpeekRequest("!room:test", "remote.test")
+	var unpeekRes fsAPI.PerformUnpeekResponse
+	if err := f.PerformUnpeek(context.Background(), &unpeekReq, &unpeekRes); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fc.unpeekCalls != 0 {
+		t.Fatalf("expected no Unpeek call for an untracked (room, user, device), got %d", fc.unpeekCalls)
+	}
+}
@@ -0,0 +1,27 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import "context"
+
+// RoomserverInputAPI is the subset of the roomserver's internal API that
+// other components need in order to feed events into the roomserver for
+// processing and persistence. It is not a complete description of the
+// roomserver's internal API (see roomserver/internal for that) - just the
+// part needed by callers, such as the federationsender, which only submit
+// events rather than performing full roomserver operations.
+type RoomserverInputAPI interface {
+	InputRoomEvents(ctx context.Context, req *InputRoomEventsRequest, res *InputRoomEventsResponse) error
+}
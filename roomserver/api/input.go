@@ -0,0 +1,52 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import "github.com/matrix-org/gomatrixserverlib"
+
+// Kind tells the roomserver how to treat an event submitted via
+// InputRoomEvents: whether to run it through normal auth/membership
+// processing (KindNew), or to index it as-is for use in auth and state
+// resolution without applying it to the room (KindOutlier) - the latter is
+// used for events obtained out-of-band, such as the state and auth chain
+// returned by a federated join or an MSC2753 federated peek.
+type Kind int
+
+const (
+	KindOutlier Kind = iota + 1
+	KindNew
+)
+
+// InputRoomEvent is a single event, plus the metadata the roomserver needs
+// to process and persist it, submitted via InputRoomEvents.
+type InputRoomEvent struct {
+	Kind          Kind
+	Event         *gomatrixserverlib.HeaderedEvent
+	AuthEventIDs  []string
+	HasState      bool
+	StateEventIDs []string
+}
+
+// InputRoomEventsRequest submits one or more events, in order, to the
+// roomserver for processing and persistence.
+type InputRoomEventsRequest struct {
+	InputRoomEvents []InputRoomEvent
+}
+
+// InputRoomEventsResponse is the result of an InputRoomEventsRequest.
+// ErrMsg is non-empty if any of the submitted events failed to process.
+type InputRoomEventsResponse struct {
+	ErrMsg string
+}
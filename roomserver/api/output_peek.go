@@ -0,0 +1,29 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+// OutputTypeRetirePeek is the counterpart to OutputTypeNewPeek: it is
+// emitted when a previously-registered peek is torn down by PerformUnpeek,
+// so that the sync API can stop including the room in that device's /sync
+// responses.
+const OutputTypeRetirePeek = "retire_peek"
+
+// OutputRetirePeek is the payload of an OutputEvent with
+// Type == OutputTypeRetirePeek.
+type OutputRetirePeek struct {
+	RoomID   string
+	UserID   string
+	DeviceID string
+}
@@ -0,0 +1,28 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+// PerformUnpeekRequest asks the roomserver to stop peeking into RoomID on
+// behalf of UserID/DeviceID. It is the counterpart to PerformPeekRequest.
+type PerformUnpeekRequest struct {
+	RoomID   string
+	UserID   string
+	DeviceID string
+}
+
+// PerformUnpeekResponse is the result of a PerformUnpeekRequest.
+type PerformUnpeekResponse struct {
+	Error *PerformError
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package helpers contains small utility functions shared by the various
+// roomserver/internal perform* implementations, which would otherwise end
+// up duplicating the same logic against the roomserver storage layer.
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/matrix-org/dendrite/roomserver/storage"
+	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/gomatrixserverlib"
+	"github.com/matrix-org/util"
+)
+
+// HistoryVisibility is the effective value of a room's
+// m.room.history_visibility state, as used to decide how much of a room's
+// history a given request is entitled to see.
+type HistoryVisibility int
+
+const (
+	// HistoryVisibilityWorldReadable means that anyone, including users
+	// and servers that have never joined the room, may see the history.
+	HistoryVisibilityWorldReadable HistoryVisibility = iota + 1
+	// HistoryVisibilityShared means that any current member may see
+	// history from before they joined. This is the default value used
+	// when the m.room.history_visibility event is missing or its value is
+	// unrecognised.
+	HistoryVisibilityShared
+	// HistoryVisibilityInvited means that members may see history from
+	// the point they were invited onwards.
+	HistoryVisibilityInvited
+	// HistoryVisibilityJoined means that members may see history only
+	// from the point they joined onwards.
+	HistoryVisibilityJoined
+)
+
+// CheckHistoryVisibility returns the history_visibility that applies to
+// roomID, evaluated at atEventNIDs, or at the room's current state if
+// atEventNIDs is empty. It does not by itself decide whether userID is
+// allowed to see that history - callers combine the returned value with
+// whatever they know about userID's membership (or lack of one, in the
+// case of a peek) to make that decision; userID is accepted here purely so
+// that future callers with NID-scoped, membership-dependent visibility
+// rules (e.g. "was userID invited by this point in the room's history?")
+// have somewhere to plug that in without changing the signature again.
+//
+// Per the Matrix spec, a missing or unrecognised m.room.history_visibility
+// event defaults to "shared", so this never returns an error just because
+// the event doesn't exist.
+//
+// TODO: peek admission (roomserver/internal.allowedToPeek) is currently the
+// only caller. Backfill and /messages still enforce history_visibility with
+// their own, separate logic and have not been migrated to call this - doing
+// so, so that remote peekers and local users get consistent enforcement, is
+// tracked as a follow-up and is out of scope for the peek work this was
+// introduced for.
+func CheckHistoryVisibility(
+	ctx context.Context,
+	db storage.Database,
+	roomID string,
+	userID string,
+	atEventNIDs []types.EventNID,
+) (HistoryVisibility, error) {
+	var ev *gomatrixserverlib.HeaderedEvent
+	var err error
+	if len(atEventNIDs) == 0 {
+		ev, err = db.GetStateEvent(ctx, roomID, "m.room.history_visibility", "")
+	} else {
+		ev, err = db.GetStateEventAtNIDs(ctx, roomID, "m.room.history_visibility", "", atEventNIDs)
+	}
+	if err != nil {
+		return HistoryVisibilityShared, err
+	}
+
+	// No m.room.history_visibility event (or the room itself isn't known
+	// to us at all) - use the Matrix default.
+	if ev == nil {
+		return HistoryVisibilityShared, nil
+	}
+
+	content := map[string]string{}
+	if err = json.Unmarshal(ev.Content(), &content); err != nil {
+		util.GetLogger(ctx).WithError(err).Error("json.Unmarshal for history visibility failed")
+		return HistoryVisibilityShared, err
+	}
+
+	return visibilityFromContent(content), nil
+}
+
+// visibilityFromContent maps the decoded content of an
+// m.room.history_visibility event to a HistoryVisibility, falling back to
+// the Matrix default of "shared" for the literal value "shared" or for
+// anything we don't recognise. It is a plain function, rather than being
+// inlined into CheckHistoryVisibility, so that the value mapping can be
+// unit tested without needing a real event to decode.
+func visibilityFromContent(content map[string]string) HistoryVisibility {
+	switch content["history_visibility"] {
+	case "world_readable":
+		return HistoryVisibilityWorldReadable
+	case "invited":
+		return HistoryVisibilityInvited
+	case "joined":
+		return HistoryVisibilityJoined
+	default:
+		return HistoryVisibilityShared
+	}
+}
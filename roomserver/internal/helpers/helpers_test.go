@@ -0,0 +1,111 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package helpers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// fakeDatabase is a minimal storage.Database good enough to drive
+// CheckHistoryVisibility in tests, without needing a real roomserver
+// storage backend. It also records whether GetStateEventAtNIDs was used
+// in preference to GetStateEvent, so the NID-scoped lookup path can be
+// exercised without needing to decode a real event.
+type fakeDatabase struct {
+	currentStateEvent *gomatrixserverlib.HeaderedEvent
+	atNIDsStateEvent  *gomatrixserverlib.HeaderedEvent
+	usedAtNIDs        bool
+}
+
+func (f *fakeDatabase) GetStateEvent(ctx context.Context, roomID, evType, stateKey string) (*gomatrixserverlib.HeaderedEvent, error) {
+	return f.currentStateEvent, nil
+}
+
+func (f *fakeDatabase) GetStateEventAtNIDs(ctx context.Context, roomID, evType, stateKey string, atEventNIDs []types.EventNID) (*gomatrixserverlib.HeaderedEvent, error) {
+	f.usedAtNIDs = true
+	return f.atNIDsStateEvent, nil
+}
+
+func (f *fakeDatabase) GetRoomIDForAlias(ctx context.Context, alias string) (string, error) {
+	return "", nil
+}
+
+func (f *fakeDatabase) GetMembershipForUser(ctx context.Context, roomID, userID string) (string, error) {
+	return "", nil
+}
+
+func (f *fakeDatabase) AddPeek(ctx context.Context, roomID, userID, deviceID string) error {
+	return nil
+}
+
+func (f *fakeDatabase) DeletePeek(ctx context.Context, roomID, userID, deviceID string) error {
+	return nil
+}
+
+func (f *fakeDatabase) PeekExists(ctx context.Context, roomID, userID, deviceID string) (bool, error) {
+	return false, nil
+}
+
+func TestVisibilityFromContentMapsKnownValues(t *testing.T) {
+	cases := map[string]HistoryVisibility{
+		"world_readable": HistoryVisibilityWorldReadable,
+		"shared":         HistoryVisibilityShared,
+		"invited":        HistoryVisibilityInvited,
+		"joined":         HistoryVisibilityJoined,
+		"nonsense":       HistoryVisibilityShared,
+		"":               HistoryVisibilityShared,
+	}
+	for value, want := range cases {
+		got := visibilityFromContent(map[string]string{"history_visibility": value})
+		if got != want {
+			t.Errorf("history_visibility %q: got %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestCheckHistoryVisibilityDefaultsToSharedWhenEventMissing(t *testing.T) {
+	db := &fakeDatabase{}
+	visibility, err := CheckHistoryVisibility(context.Background(), db, "!room:test", "@alice:test", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if visibility != HistoryVisibilityShared {
+		t.Fatalf("expected HistoryVisibilityShared when there's no history_visibility event, got %v", visibility)
+	}
+}
+
+func TestCheckHistoryVisibilityUsesAtEventNIDsWhenGiven(t *testing.T) {
+	db := &fakeDatabase{}
+	if _, err := CheckHistoryVisibility(context.Background(), db, "!room:test", "@alice:test", []types.EventNID{1}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !db.usedAtNIDs {
+		t.Fatalf("expected CheckHistoryVisibility to use GetStateEventAtNIDs when atEventNIDs is non-empty")
+	}
+}
+
+func TestCheckHistoryVisibilityUsesCurrentStateWhenNoNIDsGiven(t *testing.T) {
+	db := &fakeDatabase{}
+	if _, err := CheckHistoryVisibility(context.Background(), db, "!room:test", "@alice:test", nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if db.usedAtNIDs {
+		t.Fatalf("expected CheckHistoryVisibility to use GetStateEvent, not GetStateEventAtNIDs, when atEventNIDs is empty")
+	}
+}
@@ -16,12 +16,13 @@ package internal
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"strings"
 
 	fsAPI "github.com/matrix-org/dendrite/federationsender/api"
 	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/dendrite/roomserver/internal/helpers"
+	"github.com/matrix-org/dendrite/roomserver/storage"
 	"github.com/matrix-org/gomatrixserverlib"
 	"github.com/matrix-org/util"
 	"github.com/sirupsen/logrus"
@@ -146,49 +147,178 @@ func (r *RoomserverInternalAPI) performPeekRoomByID(
 		req.ServerNames = append(req.ServerNames, domain)
 	}
 
-	// If this room isn't world_readable, we reject.
+	// Work out how much of the room's history userID is allowed to see,
+	// and whether that's enough to admit this peek.
 	// XXX: would be nicer to call this with NIDs
-	// XXX: we should probably factor out history_visibility checks into a common utility method somewhere
-	// which handles the default value etc.
-	var worldReadable = false
-	ev, err := r.DB.GetStateEvent(ctx, roomID, "m.room.history_visibility", "")
-	if ev != nil {
-		content := map[string]string{}
-		if err = json.Unmarshal(ev.Content(), &content); err != nil {
-			util.GetLogger(ctx).WithError(err).Error("json.Unmarshal for history visibility failed")
-			return
-		}
-		if visibility, ok := content["history_visibility"]; ok {
-			worldReadable = visibility == "world_readable"
+	visibility, err := helpers.CheckHistoryVisibility(ctx, r.DB, roomID, req.UserID, nil)
+	if err != nil {
+		return "", err
+	}
+
+	allowed, err := allowedToPeek(ctx, r.DB, roomID, req.UserID, visibility)
+	if err != nil {
+		return "", err
+	}
+
+	// If we're not allowed to peek with what we know locally (either we
+	// don't know about the room at all, or its history_visibility doesn't
+	// allow it), then fall back to trying a federated peek against whichever
+	// servers we were given, rather than rejecting outright.
+	if !allowed {
+		if len(req.ServerNames) == 0 {
+			return "", &api.PerformError{
+				Code: api.PerformErrorNotAllowed,
+				Msg:  "Room is not world-readable",
+			}
 		}
+		return r.performPeekRemote(ctx, req)
+	}
+
+	if err = r.addPeek(ctx, roomID, req.UserID, req.DeviceID); err != nil {
+		return
 	}
 
-	if !worldReadable {
+	// By this point, if req.RoomIDOrAlias contained an alias, then
+	// it will have been overwritten with a room ID by performPeekRoomByAlias.
+	// We should now include this in the response so that the CS API can
+	// return the right room ID.
+	return roomID, nil
+}
+
+// performPeekRemote asks the federationsender to peek a room that we don't
+// have a local, world-readable copy of. This is the MSC2753 federated peek
+// path: for each server in req.ServerNames, in turn, we ask the
+// federationsender to PUT /peek/{roomID}/{peekID} to that server. On
+// success, the federationsender will have already fed the returned room
+// state and auth chain into the roomserver via the same input path that
+// performJoin uses for a federated join, so the room will be known to us
+// locally by the time PerformPeek returns. The federationsender also takes
+// care of periodically renewing the peek with the remote server, and of
+// feeding whatever each renewal returns into the roomserver through that
+// same input path, until the peek is torn down with PerformUnpeek.
+func (r *RoomserverInternalAPI) performPeekRemote(
+	ctx context.Context,
+	req *api.PerformPeekRequest,
+) (string, error) {
+	roomID := req.RoomIDOrAlias
+
+	err := tryServerNamesInOrder(req.ServerNames, func(serverName gomatrixserverlib.ServerName) error {
+		fsReq := fsAPI.PerformPeekRequest{
+			RoomID:     roomID,
+			ServerName: serverName,
+			UserID:     req.UserID,
+			DeviceID:   req.DeviceID,
+		}
+		fsRes := fsAPI.PerformPeekResponse{}
+		return r.fsAPI.PerformPeek(ctx, &fsReq, &fsRes)
+	}, func(serverName gomatrixserverlib.ServerName, err error) {
+		util.GetLogger(ctx).WithError(err).WithField("server_name", serverName).
+			Warn("Failed to peek room by federation, trying next server")
+	})
+	if err != nil {
 		return "", &api.PerformError{
-			Code: api.PerformErrorNotAllowed,
-			Msg: "Room is not world-readable",
+			Code: api.PerformErrorNoRoom,
+			Msg:  fmt.Sprintf("Failed to peek room %q over federation: %s", roomID, err),
 		}
 	}
 
-	// TODO: handle federated peeks
+	if err = r.addPeek(ctx, roomID, req.UserID, req.DeviceID); err != nil {
+		return "", err
+	}
 
-	err = r.WriteOutputEvents(roomID, []api.OutputEvent{
+	return roomID, nil
+}
+
+// tryServerNamesInOrder calls try with each of serverNames in turn, stopping
+// at (and returning nil for) the first one that succeeds. onFailure, if
+// non-nil, is called with each server that fails before moving on to the
+// next. If serverNames is empty, or every server fails, the last error
+// encountered is returned (or a generic "no candidate servers" error if
+// serverNames was empty). It is pulled out of performPeekRemote as a plain
+// function so that the multi-server fallback behaviour can be unit tested
+// without needing a full RoomserverInternalAPI.
+func tryServerNamesInOrder(
+	serverNames []gomatrixserverlib.ServerName,
+	try func(serverName gomatrixserverlib.ServerName) error,
+	onFailure func(serverName gomatrixserverlib.ServerName, err error),
+) error {
+	var lastErr error
+	for _, serverName := range serverNames {
+		if lastErr = try(serverName); lastErr == nil {
+			return nil
+		}
+		if onFailure != nil {
+			onFailure(serverName, lastErr)
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no candidate servers given")
+	}
+	return lastErr
+}
+
+// allowedToPeek decides whether userID may peek into roomID, given the
+// room's effective history_visibility. world_readable always allows it,
+// since that's precisely what it means. Anything less open requires userID
+// to currently hold a membership in the room that the
+// visibility setting permits seeing history from - a plain peeker with no
+// membership at all is never admitted by shared/invited/joined, which
+// exist to gate how much history a *member* can see, not whether a
+// non-member may look in at all. It's a plain function, rather than a
+// method, so the admission decision can be unit tested against a fake
+// storage.Database without needing a full RoomserverInternalAPI.
+func allowedToPeek(
+	ctx context.Context,
+	db storage.Database,
+	roomID, userID string,
+	visibility helpers.HistoryVisibility,
+) (bool, error) {
+	if visibility == helpers.HistoryVisibilityWorldReadable {
+		return true, nil
+	}
+
+	membership, err := db.GetMembershipForUser(ctx, roomID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	switch visibility {
+	case helpers.HistoryVisibilityShared, helpers.HistoryVisibilityJoined:
+		return membership == gomatrixserverlib.Join, nil
+	case helpers.HistoryVisibilityInvited:
+		return membership == gomatrixserverlib.Join || membership == gomatrixserverlib.Invite, nil
+	default:
+		return false, nil
+	}
+}
+
+// addPeek registers that the given user/device is now peeking into roomID,
+// both by recording it in the peeks table (so that re-peeking from the same
+// device is a no-op rather than creating a duplicate entry) and by emitting
+// an OutputTypeNewPeek so that the sync API starts including the room in
+// that device's /sync responses.
+func (r *RoomserverInternalAPI) addPeek(
+	ctx context.Context,
+	roomID, userID, deviceID string,
+) error {
+	exists, err := r.DB.PeekExists(ctx, roomID, userID, deviceID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if err := r.DB.AddPeek(ctx, roomID, userID, deviceID); err != nil {
+		return err
+	}
+	return r.WriteOutputEvents(roomID, []api.OutputEvent{
 		{
 			Type: api.OutputTypeNewPeek,
 			NewPeek: &api.OutputNewPeek{
-				RoomID: roomID,
-				UserID: req.UserID,
-				DeviceID: req.DeviceID,
+				RoomID:   roomID,
+				UserID:   userID,
+				DeviceID: deviceID,
 			},
 		},
 	})
-	if err != nil {
-		return
-	}
-
-	// By this point, if req.RoomIDOrAlias contained an alias, then
-	// it will have been overwritten with a room ID by performPeekRoomByAlias.
-	// We should now include this in the response so that the CS API can
-	// return the right room ID.
-	return roomID, nil;
 }
@@ -0,0 +1,128 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	fsAPI "github.com/matrix-org/dendrite/federationsender/api"
+	"github.com/matrix-org/dendrite/roomserver/api"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// PerformUnpeek handles un-peeking from rooms, including over federation by talking to the federationsender.
+func (r *RoomserverInternalAPI) PerformUnpeek(
+	ctx context.Context,
+	req *api.PerformUnpeekRequest,
+	res *api.PerformUnpeekResponse,
+) {
+	err := r.performUnpeek(ctx, req)
+	if err != nil {
+		perr, ok := err.(*api.PerformError)
+		if ok {
+			res.Error = perr
+		} else {
+			res.Error = &api.PerformError{
+				Msg: err.Error(),
+			}
+		}
+	}
+}
+
+func (r *RoomserverInternalAPI) performUnpeek(
+	ctx context.Context,
+	req *api.PerformUnpeekRequest,
+) error {
+	// FIXME: there's way too much duplication with performPeek
+	_, domain, err := gomatrixserverlib.SplitID('@', req.UserID)
+	if err != nil {
+		return &api.PerformError{
+			Code: api.PerformErrorBadRequest,
+			Msg:  fmt.Sprintf("Supplied user ID %q in incorrect format", req.UserID),
+		}
+	}
+	if domain != r.Cfg.Matrix.ServerName {
+		return &api.PerformError{
+			Code: api.PerformErrorBadRequest,
+			Msg:  fmt.Sprintf("User %q does not belong to this homeserver", req.UserID),
+		}
+	}
+
+	// If this (room, user, device) isn't currently peeking then there's
+	// nothing to tear down, so this is a no-op rather than an error, to
+	// keep PerformUnpeek idempotent in the same way as PerformPeek.
+	found, err := r.DB.PeekExists(ctx, req.RoomID, req.UserID, req.DeviceID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+
+	if err = r.DB.DeletePeek(ctx, req.RoomID, req.UserID, req.DeviceID); err != nil {
+		return err
+	}
+
+	if err = r.WriteOutputEvents(req.RoomID, []api.OutputEvent{
+		{
+			Type: api.OutputTypeRetirePeek,
+			RetirePeek: &api.OutputRetirePeek{
+				RoomID:   req.RoomID,
+				UserID:   req.UserID,
+				DeviceID: req.DeviceID,
+			},
+		},
+	}); err != nil {
+		return err
+	}
+
+	// If the room isn't one of ours then ask the federationsender to tear
+	// down the MSC2753 peek it is holding open with the origin server too.
+	local, roomDomain, err := isLocalRoom(req.RoomID, r.Cfg.Matrix.ServerName)
+	if err != nil {
+		return &api.PerformError{
+			Code: api.PerformErrorBadRequest,
+			Msg:  fmt.Sprintf("Room ID %q is invalid: %s", req.RoomID, err),
+		}
+	}
+	if !local {
+		fsReq := fsAPI.PerformUnpeekRequest{
+			RoomID:     req.RoomID,
+			ServerName: roomDomain,
+			UserID:     req.UserID,
+			DeviceID:   req.DeviceID,
+		}
+		fsRes := fsAPI.PerformUnpeekResponse{}
+		if err = r.fsAPI.PerformUnpeek(ctx, &fsReq, &fsRes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isLocalRoom reports whether roomID belongs to ourServerName, along with
+// the domain part of roomID so callers don't need to split it again. It is
+// a plain function, rather than a method, purely so that the local-vs-
+// remote branch in performUnpeek can be unit tested without needing a full
+// RoomserverInternalAPI.
+func isLocalRoom(roomID string, ourServerName gomatrixserverlib.ServerName) (bool, gomatrixserverlib.ServerName, error) {
+	_, domain, err := gomatrixserverlib.SplitID('!', roomID)
+	if err != nil {
+		return false, "", err
+	}
+	return domain == ourServerName, domain, nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+func TestIsLocalRoom(t *testing.T) {
+	cases := []struct {
+		roomID     string
+		serverName gomatrixserverlib.ServerName
+		wantLocal  bool
+		wantDomain gomatrixserverlib.ServerName
+		wantErr    bool
+	}{
+		{"!room:example.com", "example.com", true, "example.com", false},
+		{"!room:example.com", "elsewhere.com", false, "example.com", false},
+		{"not-a-room-id", "example.com", false, "", true},
+	}
+	for _, c := range cases {
+		local, domain, err := isLocalRoom(c.roomID, c.serverName)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("isLocalRoom(%q, %q): expected an error, got none", c.roomID, c.serverName)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("isLocalRoom(%q, %q): unexpected error: %s", c.roomID, c.serverName, err)
+			continue
+		}
+		if local != c.wantLocal {
+			t.Errorf("isLocalRoom(%q, %q): local = %v, want %v", c.roomID, c.serverName, local, c.wantLocal)
+		}
+		if domain != c.wantDomain {
+			t.Errorf("isLocalRoom(%q, %q): domain = %q, want %q", c.roomID, c.serverName, domain, c.wantDomain)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inthttp contains the HTTP client/server wiring used to reach the
+// roomserver's internal API from another process. Only the PerformUnpeek
+// route is defined here; the rest of the roomserver's internal API has its
+// own routes defined alongside it in the wider package.
+package inthttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/matrix-org/dendrite/roomserver/api"
+)
+
+// PerformUnpeekPath is the HTTP path the roomserver's PerformUnpeek is
+// reachable under when accessed via the internal HTTP API.
+const PerformUnpeekPath = "/roomserver/performUnpeek"
+
+// RoomserverInternalAPIClient is the HTTP client used to reach a
+// roomserver's internal API from another process. Only the PerformUnpeek
+// method is implemented here; the rest of api.RoomserverInternalAPI has its
+// client methods defined alongside this one in the wider package.
+type RoomserverInternalAPIClient struct {
+	RoomserverURL string
+	HTTPClient    *http.Client
+}
+
+// PerformUnpeek calls the roomserver's PerformUnpeek over HTTP.
+func (h *RoomserverInternalAPIClient) PerformUnpeek(
+	ctx context.Context,
+	request *api.PerformUnpeekRequest,
+	response *api.PerformUnpeekResponse,
+) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.RoomserverURL+PerformUnpeekPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpRes, err := h.HTTPClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpRes.Body.Close() // nolint: errcheck
+
+	if httpRes.StatusCode != http.StatusOK {
+		return fmt.Errorf("inthttp PerformUnpeek: unexpected HTTP status %d", httpRes.StatusCode)
+	}
+	return json.NewDecoder(httpRes.Body).Decode(response)
+}
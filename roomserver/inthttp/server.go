@@ -0,0 +1,47 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inthttp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/matrix-org/dendrite/roomserver/api"
+)
+
+// unpeekPerformer is the part of the roomserver's internal API that
+// AddRoutePerformUnpeek needs - satisfied by *internal.RoomserverInternalAPI.
+type unpeekPerformer interface {
+	PerformUnpeek(ctx context.Context, req *api.PerformUnpeekRequest, res *api.PerformUnpeekResponse)
+}
+
+// AddRoutePerformUnpeek registers the PerformUnpeek handler on mux, so that
+// RoomserverInternalAPIClient.PerformUnpeek can reach it over HTTP.
+func AddRoutePerformUnpeek(internalAPI unpeekPerformer, mux *http.ServeMux) {
+	mux.HandleFunc(PerformUnpeekPath,
+		func(w http.ResponseWriter, req *http.Request) {
+			var request api.PerformUnpeekRequest
+			if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var response api.PerformUnpeekResponse
+			internalAPI.PerformUnpeek(req.Context(), &request, &response)
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(&response)
+		},
+	)
+}
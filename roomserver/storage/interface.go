@@ -0,0 +1,66 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+
+	"github.com/matrix-org/dendrite/roomserver/types"
+	"github.com/matrix-org/gomatrixserverlib"
+)
+
+// Database is the set of storage operations that roomserver/internal's
+// perform* implementations use. This is not a complete description of the
+// roomserver's storage interface - only the methods exercised by the
+// peek/unpeek and history-visibility code are declared here; the rest of
+// the interface lives alongside the wider roomserver package.
+type Database interface {
+	// GetStateEvent returns the current state event of the given type and
+	// state key in roomID, or nil if there is no such event (including if
+	// the room itself is unknown).
+	GetStateEvent(ctx context.Context, roomID, evType, stateKey string) (*gomatrixserverlib.HeaderedEvent, error)
+
+	// GetStateEventAtNIDs returns the state event of the given type and
+	// state key that was in effect at atEventNIDs (the state before/after
+	// some set of events, e.g. when filtering backfill or /messages
+	// results), or nil if there is no such event.
+	GetStateEventAtNIDs(ctx context.Context, roomID, evType, stateKey string, atEventNIDs []types.EventNID) (*gomatrixserverlib.HeaderedEvent, error)
+
+	// GetRoomIDForAlias returns the room ID published under alias, or ""
+	// if the alias isn't known locally.
+	GetRoomIDForAlias(ctx context.Context, alias string) (string, error)
+
+	// GetMembershipForUser returns userID's current membership in roomID
+	// ("join", "invite", "leave", "ban"), or "" if userID has never had a
+	// membership in roomID.
+	GetMembershipForUser(ctx context.Context, roomID, userID string) (string, error)
+
+	PeeksTable
+}
+
+// PeeksTable tracks which (room, user, device) tuples currently hold an
+// open peek into a room, so that PerformPeek can be idempotent and
+// PerformUnpeek has something to remove.
+type PeeksTable interface {
+	// AddPeek records that (roomID, userID, deviceID) now holds a peek of
+	// roomID. It is safe to call more than once for the same tuple.
+	AddPeek(ctx context.Context, roomID, userID, deviceID string) error
+	// DeletePeek removes the peek recorded for (roomID, userID, deviceID),
+	// if any. It is not an error to call it for a tuple with no peek.
+	DeletePeek(ctx context.Context, roomID, userID, deviceID string) error
+	// PeekExists reports whether (roomID, userID, deviceID) currently
+	// holds a peek of roomID.
+	PeekExists(ctx context.Context, roomID, userID, deviceID string) (bool, error)
+}
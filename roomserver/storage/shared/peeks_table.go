@@ -0,0 +1,106 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package shared contains storage code shared between the postgres and
+// sqlite roomserver storage backends.
+package shared
+
+import (
+	"context"
+	"database/sql"
+)
+
+const peeksSchema = `
+CREATE TABLE IF NOT EXISTS roomserver_peeks (
+	room_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	device_id TEXT NOT NULL,
+	deleted BOOLEAN NOT NULL DEFAULT false,
+	UNIQUE (room_id, user_id, device_id)
+);
+`
+
+// Both backends accept $1, $2, ... placeholders via lib/pq and sqlite3
+// drivers configured elsewhere in the storage layer, so the SQL here is
+// shared rather than duplicated per-backend.
+const upsertPeekSQL = "" +
+	"INSERT INTO roomserver_peeks (room_id, user_id, device_id, deleted)" +
+	" VALUES ($1, $2, $3, false)" +
+	" ON CONFLICT (room_id, user_id, device_id)" +
+	" DO UPDATE SET deleted = false"
+
+const deletePeekSQL = "" +
+	"UPDATE roomserver_peeks SET deleted = true" +
+	" WHERE room_id = $1 AND user_id = $2 AND device_id = $3"
+
+const selectPeekSQL = "" +
+	"SELECT deleted FROM roomserver_peeks" +
+	" WHERE room_id = $1 AND user_id = $2 AND device_id = $3"
+
+// PeeksTable is the shared, database/sql-backed implementation of
+// storage.PeeksTable used by both the postgres and sqlite roomserver
+// storage backends.
+type PeeksTable struct {
+	db             *sql.DB
+	upsertPeekStmt *sql.Stmt
+	deletePeekStmt *sql.Stmt
+	selectPeekStmt *sql.Stmt
+}
+
+// NewPeeksTable prepares the roomserver_peeks table and its statements
+// against db, creating the table first if it doesn't already exist.
+func NewPeeksTable(db *sql.DB) (*PeeksTable, error) {
+	t := &PeeksTable{db: db}
+	if _, err := db.Exec(peeksSchema); err != nil {
+		return nil, err
+	}
+	var err error
+	if t.upsertPeekStmt, err = db.Prepare(upsertPeekSQL); err != nil {
+		return nil, err
+	}
+	if t.deletePeekStmt, err = db.Prepare(deletePeekSQL); err != nil {
+		return nil, err
+	}
+	if t.selectPeekStmt, err = db.Prepare(selectPeekSQL); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// AddPeek implements storage.PeeksTable. Re-adding a peek that already
+// exists (deleted or not) just marks it live again, rather than erroring.
+func (t *PeeksTable) AddPeek(ctx context.Context, roomID, userID, deviceID string) error {
+	_, err := t.upsertPeekStmt.ExecContext(ctx, roomID, userID, deviceID)
+	return err
+}
+
+// DeletePeek implements storage.PeeksTable. It is not an error to delete a
+// peek that doesn't exist.
+func (t *PeeksTable) DeletePeek(ctx context.Context, roomID, userID, deviceID string) error {
+	_, err := t.deletePeekStmt.ExecContext(ctx, roomID, userID, deviceID)
+	return err
+}
+
+// PeekExists implements storage.PeeksTable.
+func (t *PeeksTable) PeekExists(ctx context.Context, roomID, userID, deviceID string) (bool, error) {
+	var deleted bool
+	err := t.selectPeekStmt.QueryRowContext(ctx, roomID, userID, deviceID).Scan(&deleted)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !deleted, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func mustOpenPeeksTable(t *testing.T) *PeeksTable {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open in-memory database: %s", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	table, err := NewPeeksTable(db)
+	if err != nil {
+		t.Fatalf("failed to prepare peeks table: %s", err)
+	}
+	return table
+}
+
+func TestAddPeekIsIdempotent(t *testing.T) {
+	table := mustOpenPeeksTable(t)
+	ctx := context.Background()
+
+	if err := table.AddPeek(ctx, "!room:test", "@alice:test", "DEVICE1"); err != nil {
+		t.Fatalf("first AddPeek failed: %s", err)
+	}
+	if err := table.AddPeek(ctx, "!room:test", "@alice:test", "DEVICE1"); err != nil {
+		t.Fatalf("second AddPeek for the same device should not error: %s", err)
+	}
+
+	exists, err := table.PeekExists(ctx, "!room:test", "@alice:test", "DEVICE1")
+	if err != nil {
+		t.Fatalf("PeekExists failed: %s", err)
+	}
+	if !exists {
+		t.Fatal("expected peek to exist after AddPeek")
+	}
+}
+
+func TestDeletePeekThenReAdd(t *testing.T) {
+	table := mustOpenPeeksTable(t)
+	ctx := context.Background()
+
+	if err := table.AddPeek(ctx, "!room:test", "@alice:test", "DEVICE1"); err != nil {
+		t.Fatalf("AddPeek failed: %s", err)
+	}
+	if err := table.DeletePeek(ctx, "!room:test", "@alice:test", "DEVICE1"); err != nil {
+		t.Fatalf("DeletePeek failed: %s", err)
+	}
+
+	exists, err := table.PeekExists(ctx, "!room:test", "@alice:test", "DEVICE1")
+	if err != nil {
+		t.Fatalf("PeekExists failed: %s", err)
+	}
+	if exists {
+		t.Fatal("expected peek to no longer exist after DeletePeek")
+	}
+
+	// Re-peeking from the same device afterwards should work again.
+	if err := table.AddPeek(ctx, "!room:test", "@alice:test", "DEVICE1"); err != nil {
+		t.Fatalf("re-AddPeek after delete failed: %s", err)
+	}
+	exists, err = table.PeekExists(ctx, "!room:test", "@alice:test", "DEVICE1")
+	if err != nil {
+		t.Fatalf("PeekExists failed: %s", err)
+	}
+	if !exists {
+		t.Fatal("expected peek to exist again after re-AddPeek")
+	}
+}
+
+func TestDeletePeekThatDoesNotExist(t *testing.T) {
+	table := mustOpenPeeksTable(t)
+	ctx := context.Background()
+
+	if err := table.DeletePeek(ctx, "!room:test", "@alice:test", "DEVICE1"); err != nil {
+		t.Fatalf("DeletePeek of a non-existent peek should not error: %s", err)
+	}
+	exists, err := table.PeekExists(ctx, "!room:test", "@alice:test", "DEVICE1")
+	if err != nil {
+		t.Fatalf("PeekExists failed: %s", err)
+	}
+	if exists {
+		t.Fatal("expected no peek to exist")
+	}
+}
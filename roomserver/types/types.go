@@ -0,0 +1,21 @@
+// Copyright 2020 New Vector Ltd
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package types contains small shared types used across the roomserver
+// storage and internal packages. Only EventNID is declared here; the rest
+// of the roomserver's NID types live alongside it in the wider package.
+package types
+
+// EventNID identifies a single event within the roomserver's storage.
+type EventNID int64